@@ -0,0 +1,138 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodescaler
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// defaultAllocationWindow is how far back a GameServerCounter looks when
+// computing AllocationRate, if the caller doesn't configure one.
+const defaultAllocationWindow = 5 * time.Minute
+
+// GameServerCounter tracks recent game session pod churn, and uses it to
+// predict near term demand - so scaleNodes can pre-warm nodes ahead of a
+// rising allocation trend, rather than only reacting once the existing
+// buffer is consumed.
+type GameServerCounter interface {
+	// AllocationRate returns the net game session pods created per
+	// second, over the counter's sliding window.
+	AllocationRate() float64
+
+	// PredictedDemand returns the expected number of additional game
+	// sessions over the given horizon, extrapolated from AllocationRate.
+	// It is never negative.
+	PredictedDemand(horizon time.Duration) int64
+}
+
+// sessionEvent is a single game session pod creation or deletion, as
+// observed by the informer.
+type sessionEvent struct {
+	at    time.Time
+	delta int64 // +1 for a pod add, -1 for a pod delete
+}
+
+// slidingWindowCounter is the default GameServerCounter implementation. It
+// keeps a sliding window of session pod add/delete events, and derives
+// AllocationRate and PredictedDemand from the net of those events.
+type slidingWindowCounter struct {
+	now    func() time.Time
+	window time.Duration
+
+	mu     sync.Mutex
+	events []sessionEvent
+}
+
+// NewGameServerCounter creates a GameServerCounter with the given sliding
+// window (defaultAllocationWindow if window is <= 0), using now as its
+// time source - normally Server.clock.Now, so tests can inject a
+// deterministic clock.
+func NewGameServerCounter(window time.Duration, now func() time.Time) GameServerCounter {
+	if window <= 0 {
+		window = defaultAllocationWindow
+	}
+	return &slidingWindowCounter{now: now, window: window}
+}
+
+// EventHandler returns the informer callbacks that feed this counter.
+// Only pods matching the existing sessions=game convention (see
+// filterGameSessionPods) are counted.
+func (c *slidingWindowCounter) EventHandler() cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { c.recordObj(obj, 1) },
+		DeleteFunc: func(obj interface{}) { c.recordObj(obj, -1) },
+	}
+}
+
+// recordObj records a game session pod add/delete if obj is a v1.Pod
+// matching filterGameSessionPods; anything else is ignored.
+func (c *slidingWindowCounter) recordObj(obj interface{}, delta int64) {
+	p, ok := obj.(*v1.Pod)
+	if !ok {
+		return
+	}
+	if len(filterGameSessionPods([]v1.Pod{*p})) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, sessionEvent{at: c.now(), delta: delta})
+	c.trim()
+}
+
+// trim drops events that have fallen outside the sliding window.
+// Callers must hold c.mu.
+func (c *slidingWindowCounter) trim() {
+	cutoff := c.now().Add(-c.window)
+
+	i := 0
+	for i < len(c.events) && c.events[i].at.Before(cutoff) {
+		i++
+	}
+	c.events = c.events[i:]
+}
+
+// AllocationRate implements GameServerCounter.
+func (c *slidingWindowCounter) AllocationRate() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trim()
+
+	if len(c.events) == 0 {
+		return 0
+	}
+
+	var net int64
+	for _, e := range c.events {
+		net += e.delta
+	}
+
+	return float64(net) / c.window.Seconds()
+}
+
+// PredictedDemand implements GameServerCounter.
+func (c *slidingWindowCounter) PredictedDemand(horizon time.Duration) int64 {
+	demand := int64(math.Ceil(c.AllocationRate() * horizon.Seconds()))
+	if demand < 0 {
+		return 0
+	}
+	return demand
+}
@@ -0,0 +1,99 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodescaler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// gameSessionPod returns a pod matching the sessions=game convention that
+// recordObj filters on.
+func gameSessionPod(name string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"sessions": "game"},
+		},
+	}
+}
+
+// fakeClock lets tests move c.now() forward deterministically, instead of
+// depending on the wall clock.
+type fakeClock struct {
+	t time.Time
+}
+
+func (f *fakeClock) now() time.Time { return f.t }
+
+func (f *fakeClock) advance(d time.Duration) { f.t = f.t.Add(d) }
+
+func newTestCounter(clock *fakeClock, window time.Duration) *slidingWindowCounter {
+	return &slidingWindowCounter{now: clock.now, window: window}
+}
+
+func TestSlidingWindowCounterRisingTrend(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	c := newTestCounter(clock, time.Minute)
+
+	for i := 0; i < 6; i++ {
+		c.recordObj(gameSessionPod(fmt.Sprintf("add-%d", i)), 1)
+		clock.advance(10 * time.Second)
+	}
+
+	rate := c.AllocationRate()
+	if rate <= 0 {
+		t.Fatalf("expected a positive allocation rate for a rising trend, got %v", rate)
+	}
+
+	demand := c.PredictedDemand(time.Minute)
+	if demand <= 0 {
+		t.Errorf("expected positive predicted demand for a rising trend, got %v", demand)
+	}
+}
+
+func TestSlidingWindowCounterFallingTrend(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	c := newTestCounter(clock, time.Minute)
+
+	for i := 0; i < 6; i++ {
+		c.recordObj(gameSessionPod(fmt.Sprintf("del-%d", i)), -1)
+		clock.advance(10 * time.Second)
+	}
+
+	rate := c.AllocationRate()
+	if rate >= 0 {
+		t.Fatalf("expected a negative allocation rate for a falling trend, got %v", rate)
+	}
+
+	if demand := c.PredictedDemand(time.Minute); demand != 0 {
+		t.Errorf("expected PredictedDemand to clamp a falling trend to 0, got %v", demand)
+	}
+}
+
+func TestSlidingWindowCounterTrimsOldEvents(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	c := newTestCounter(clock, time.Minute)
+
+	c.recordObj(gameSessionPod("old"), 1)
+	clock.advance(2 * time.Minute)
+
+	if rate := c.AllocationRate(); rate != 0 {
+		t.Errorf("expected events older than the window to be trimmed, got rate %v", rate)
+	}
+}
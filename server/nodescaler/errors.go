@@ -0,0 +1,30 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodescaler
+
+import "fmt"
+
+// ErrAtMaxCapacity is returned (alongside a still-valid, partially
+// satisfied scalePlan) when scaling up would have taken the node pool
+// over its configured maximum, so that operators can alert on it rather
+// than have the request silently capped.
+type ErrAtMaxCapacity struct {
+	Zone string
+	Max  int64
+}
+
+func (e *ErrAtMaxCapacity) Error() string {
+	return fmt.Sprintf("node pool is at its max capacity of %v nodes, capped increase in zone %v", e.Max, zoneName(e.Zone))
+}
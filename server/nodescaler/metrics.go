@@ -0,0 +1,25 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodescaler
+
+// MetricsReporter is implemented by callers that want visibility into the
+// node pool's size relative to its configured bounds, for example to
+// back a set of Prometheus gauges for an operator dashboard.
+type MetricsReporter interface {
+	// ReportNodeCounts is called once per scaleNodes round with the
+	// number of currently available (uncordoned) nodes, and the node
+	// pool's min/max bounds.
+	ReportNodeCounts(available, min, max int64)
+}
@@ -0,0 +1,240 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodescaler
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// stepKind identifies what kind of action a planStep performs.
+type stepKind int
+
+const (
+	stepUncordon stepKind = iota
+	stepCordon
+	stepIncrease
+)
+
+// planStep is a single, concrete action to take against the cluster.
+// cordon/uncordon steps carry the node they target; increase steps carry
+// the zone (empty for the whole pool) and the target size.
+type planStep struct {
+	kind stepKind
+	zone string
+	node v1.Node
+	size int64
+}
+
+// verdict records, for a single zone, why planScale made the decision it
+// did. It exists so scaleNodes can log a human readable explanation of
+// the plan before executing it.
+type verdict struct {
+	zone   string
+	reason string
+}
+
+// scalePlan is the output of Server.planScale: an ordered, inspectable
+// set of steps, computed entirely from a single nodeList snapshot. A
+// scalePlan can be built and examined without ever touching the
+// Kubernetes API - only Execute performs real calls.
+type scalePlan struct {
+	server *Server
+	steps  []planStep
+
+	// VerdictSet explains the reasoning behind each per-zone decision.
+	VerdictSet []verdict
+}
+
+// policy returns the server's configured CordonPolicy, falling back to
+// CPUPackingPolicy if none was set at construction.
+func (p *scalePlan) policy() CordonPolicy {
+	if p.server.cordonPolicy != nil {
+		return p.server.cordonPolicy
+	}
+	return CPUPackingPolicy{}
+}
+
+// Execute performs the real API calls described by the plan, in order.
+// If a step fails, execution stops immediately and the remaining steps
+// are discarded - the next tick will call planScale again against fresh
+// cluster state, rather than resuming a stale, partially applied plan.
+func (p *scalePlan) Execute() error {
+	for _, step := range p.steps {
+		switch step.kind {
+		case stepUncordon:
+			if err := p.server.cordon(&step.node, false); err != nil {
+				return err
+			}
+		case stepCordon:
+			if err := p.server.cordon(&step.node, true); err != nil {
+				return err
+			}
+		case stepIncrease:
+			if step.zone == "" {
+				if err := p.server.nodePool.IncreaseToSize(step.size); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := p.server.nodePool.IncreaseZoneToSize(step.zone, step.size); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// planScale takes a snapshot of the node list and decides, zone by zone,
+// what combination of uncordon, cordon and increase steps would bring
+// each zone's buffer in line with its target - entirely speculatively,
+// via a reservation overlay on top of nl. It performs no API calls.
+//
+// min and max bound the total number of nodes the plan is allowed to
+// leave in the pool: cordon decisions will not take the available node
+// count below min, and increase decisions will not take the total node
+// count above max. If an increase would have gone over max, planScale
+// still returns a usable plan (capped at max), alongside an
+// *ErrAtMaxCapacity so the caller can alert on it.
+func (s Server) planScale(nl *nodeList, min, max int64) (*scalePlan, error) {
+	plan := &scalePlan{server: &s}
+	res := newReservation(nl)
+
+	var capErr error
+	total := int64(len(nl.nodes.Items))
+
+	increaseBuffer, cordonBuffer := s.effectiveBufferCount()
+	for _, z := range summarizeZones(nl, increaseBuffer, cordonBuffer, defaultZoneDisruptionThreshold) {
+		if z.state != zoneNormal {
+			plan.VerdictSet = append(plan.VerdictSet, verdict{
+				zone:   z.name,
+				reason: fmt.Sprintf("zone is %v, skipping cordon/delete decisions", z.state),
+			})
+			continue
+		}
+
+		available := zoneCPURequestsAvailable(nl, z.nodes, s.cpuRequest)
+		switch {
+		case available < z.buffer:
+			// max <= 0 means the pool reported no upper bound
+			room := int64(math.MaxInt64)
+			if max > 0 {
+				room = max - total
+			}
+
+			added, err := plan.planUncordonAndIncrease(nl, res, z, (z.buffer-available)*s.cpuRequest, room, max)
+			if err != nil {
+				capErr = err
+			}
+			total += added
+		case z.cordonBuffer < available:
+			plan.planCordon(nl, res, z, (available-z.cordonBuffer)*s.cpuRequest, min)
+		default:
+			plan.VerdictSet = append(plan.VerdictSet, verdict{zone: z.name, reason: "buffer already satisfied"})
+		}
+	}
+
+	return plan, capErr
+}
+
+// planUncordonAndIncrease reserves capacity on the zone's cordoned nodes,
+// most-loaded first, until cpuRequest is satisfied or there are no more
+// cordoned nodes to reserve against. Any shortfall becomes a single
+// increase step for the zone, capped so the pool-wide total never grows
+// by more than room, the number of nodes still available under max.
+// It returns the number of nodes the increase step would add, and a
+// non-nil *ErrAtMaxCapacity if room wasn't enough to fully satisfy cpuRequest.
+func (p *scalePlan) planUncordonAndIncrease(nl *nodeList, res *reservation, z zoneSummary, cpuRequest, room, max int64) (int64, error) {
+	nodes := filterZone(nl.cordonedNodes(), z.name)
+	policy := p.policy()
+	sortByScore(nodes, func(n v1.Node) float64 { return policy.ScoreForUncordon(n, nl) })
+
+	remaining := cpuRequest
+	for _, n := range nodes {
+		if remaining <= 0 {
+			break
+		}
+		if taken := res.reserve(n, remaining); taken > 0 {
+			remaining -= taken
+			p.steps = append(p.steps, planStep{kind: stepUncordon, zone: z.name, node: n})
+		}
+	}
+
+	if remaining <= 0 {
+		p.VerdictSet = append(p.VerdictSet, verdict{zone: z.name, reason: "satisfied buffer by uncordoning existing nodes"})
+		return 0, nil
+	}
+
+	capacity := nl.nodes.Items[0].Status.Capacity[v1.ResourceCPU]
+	diff := int64(math.Ceil(float64(remaining) / float64(capacity.MilliValue())))
+
+	var err error
+	if diff > room {
+		log.Printf("[Warn][planScale] Zone %v needs %v more nodes but only %v are available under the configured max of %v, capping", zoneName(z.name), diff, room, max)
+		diff = room
+		err = &ErrAtMaxCapacity{Zone: z.name, Max: max}
+	}
+	if diff <= 0 {
+		p.VerdictSet = append(p.VerdictSet, verdict{zone: z.name, reason: "already at max capacity, cannot increase further"})
+		return 0, err
+	}
+
+	size := res.availableCount(filterZone(nl.nodes.Items, z.name)) + diff
+
+	p.steps = append(p.steps, planStep{kind: stepIncrease, zone: z.name, size: size})
+	p.VerdictSet = append(p.VerdictSet, verdict{
+		zone:   z.name,
+		reason: fmt.Sprintf("uncordoning was not enough, increasing pool to %v nodes", size),
+	})
+
+	return diff, err
+}
+
+// planCordon releases (cordons) the zone's least-loaded nodes until
+// excessCPU worth of game capacity has been removed from the buffer,
+// without taking the pool-wide available node count below min.
+func (p *scalePlan) planCordon(nl *nodeList, res *reservation, z zoneSummary, excessCPU, min int64) {
+	capacity := nl.nodes.Items[0].Status.Capacity[v1.ResourceCPU]
+	diff := int64(math.Floor(float64(excessCPU) / float64(capacity.MilliValue())))
+
+	nodes := filterZone(nl.availableNodes(), z.name)
+	if int64(len(nodes)) < diff {
+		diff = int64(len(nodes))
+	}
+
+	if room := res.availableCount(nl.nodes.Items) - min; diff > room {
+		log.Printf("[Warn][planScale] Zone %v would cordon below the min of %v available nodes, capping", zoneName(z.name), min)
+		diff = room
+	}
+
+	if diff <= 0 {
+		p.VerdictSet = append(p.VerdictSet, verdict{zone: z.name, reason: "at min node count, preferring to leave nodes uncordoned"})
+		return
+	}
+
+	policy := p.policy()
+	sortByScore(nodes, func(n v1.Node) float64 { return policy.ScoreForCordon(n, nl) })
+
+	for _, n := range nodes[:diff] {
+		res.release(n)
+		p.steps = append(p.steps, planStep{kind: stepCordon, zone: z.name, node: n})
+	}
+
+	p.VerdictSet = append(p.VerdictSet, verdict{zone: z.name, reason: fmt.Sprintf("cordoning %v excess nodes", diff)})
+}
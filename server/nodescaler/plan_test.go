@@ -0,0 +1,191 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodescaler
+
+import (
+	"testing"
+
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// planTestNode builds a v1.Node with 1000m of CPU capacity - exactly one
+// cpuRequest block at the cpuRequest used throughout this file - so test
+// expectations can be expressed directly in node counts.
+func planTestNode(name, zone string, unschedulable bool) v1.Node {
+	return v1.Node{
+		ObjectMeta: v1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{zoneLabel: zone},
+		},
+		Spec: v1.NodeSpec{Unschedulable: unschedulable},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+			Capacity:   v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")},
+		},
+	}
+}
+
+// planTestServer returns a Server configured with a 1000m cpuRequest, so
+// each node in planTestNode is worth exactly one buffer block.
+func planTestServer() Server {
+	return Server{cpuRequest: 1000}
+}
+
+func TestPlanScaleUncordonSatisfiesBuffer(t *testing.T) {
+	nl := &nodeList{nodes: v1.NodeList{Items: []v1.Node{
+		planTestNode("a-avail-0", "a", false),
+		planTestNode("a-avail-1", "a", false),
+		planTestNode("a-cordoned-0", "a", true),
+	}}}
+	s := planTestServer()
+	s.bufferCount = 3
+
+	plan, err := s.planScale(nl, 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan.steps) != 1 || plan.steps[0].kind != stepUncordon {
+		t.Fatalf("expected a single uncordon step, got %+v", plan.steps)
+	}
+	if reason := plan.VerdictSet[0].reason; reason != "satisfied buffer by uncordoning existing nodes" {
+		t.Errorf("unexpected verdict reason: %v", reason)
+	}
+}
+
+func TestPlanScaleUncordonThenIncrease(t *testing.T) {
+	nl := &nodeList{nodes: v1.NodeList{Items: []v1.Node{
+		planTestNode("a-avail-0", "a", false),
+		planTestNode("a-cordoned-0", "a", true),
+	}}}
+	s := planTestServer()
+	s.bufferCount = 4
+
+	plan, err := s.planScale(nl, 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan.steps) != 2 {
+		t.Fatalf("expected an uncordon step followed by an increase step, got %+v", plan.steps)
+	}
+	if plan.steps[0].kind != stepUncordon {
+		t.Errorf("expected first step to uncordon the cordoned node, got %+v", plan.steps[0])
+	}
+	increase := plan.steps[1]
+	if increase.kind != stepIncrease || increase.zone != "a" || increase.size != 4 {
+		t.Errorf("expected an increase step to 4 nodes in zone a, got %+v", increase)
+	}
+}
+
+func TestPlanScaleCordonExcess(t *testing.T) {
+	nl := &nodeList{nodes: v1.NodeList{Items: []v1.Node{
+		planTestNode("a-avail-0", "a", false),
+		planTestNode("a-avail-1", "a", false),
+		planTestNode("a-avail-2", "a", false),
+	}}}
+	s := planTestServer()
+	s.bufferCount = 1
+
+	plan, err := s.planScale(nl, 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan.steps) != 2 {
+		t.Fatalf("expected 2 cordon steps to bring 3 available nodes down to a buffer of 1, got %+v", plan.steps)
+	}
+	for _, step := range plan.steps {
+		if step.kind != stepCordon {
+			t.Errorf("expected only cordon steps, got %+v", step)
+		}
+	}
+}
+
+func TestPlanScaleMaxCapReturnsErrAtMaxCapacity(t *testing.T) {
+	nl := &nodeList{nodes: v1.NodeList{Items: []v1.Node{
+		planTestNode("a-avail-0", "a", false),
+		planTestNode("a-avail-1", "a", false),
+	}}}
+	s := planTestServer()
+	s.bufferCount = 5
+
+	plan, err := s.planScale(nl, 0, 2)
+	if err == nil {
+		t.Fatal("expected an error when the pool is already at max capacity")
+	}
+	maxErr, ok := err.(*ErrAtMaxCapacity)
+	if !ok {
+		t.Fatalf("expected *ErrAtMaxCapacity, got %T: %v", err, err)
+	}
+	if maxErr.Zone != "a" || maxErr.Max != 2 {
+		t.Errorf("unexpected ErrAtMaxCapacity: %+v", maxErr)
+	}
+	if len(plan.steps) != 0 {
+		t.Errorf("expected no steps once capped at the existing max, got %+v", plan.steps)
+	}
+}
+
+func TestPlanScaleMinFloorStopsCordon(t *testing.T) {
+	nl := &nodeList{nodes: v1.NodeList{Items: []v1.Node{
+		planTestNode("a-avail-0", "a", false),
+	}}}
+	s := planTestServer()
+	s.bufferCount = 0
+
+	plan, err := s.planScale(nl, 1, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan.steps) != 0 {
+		t.Fatalf("expected no cordon steps once at the min node count, got %+v", plan.steps)
+	}
+	if reason := plan.VerdictSet[0].reason; reason != "at min node count, preferring to leave nodes uncordoned" {
+		t.Errorf("unexpected verdict reason: %v", reason)
+	}
+}
+
+func TestPlanScaleSkipsDisruptedZone(t *testing.T) {
+	disruptedNode := planTestNode("b-0", "b", false)
+	disruptedNode.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionFalse}}
+
+	nl := &nodeList{nodes: v1.NodeList{Items: []v1.Node{
+		planTestNode("a-0", "a", false),
+		disruptedNode,
+	}}}
+	s := planTestServer()
+	s.bufferCount = 1
+
+	plan, err := s.planScale(nl, 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var sawDisrupted bool
+	for _, v := range plan.VerdictSet {
+		if v.zone == "b" {
+			sawDisrupted = true
+			if v.reason != "zone is fullyDisrupted, skipping cordon/delete decisions" {
+				t.Errorf("unexpected verdict reason for disrupted zone: %v", v.reason)
+			}
+		}
+	}
+	if !sawDisrupted {
+		t.Fatalf("expected a verdict explaining zone b was skipped, got %+v", plan.VerdictSet)
+	}
+	for _, step := range plan.steps {
+		if step.zone == "b" {
+			t.Errorf("expected no steps in the disrupted zone, got %+v", step)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodescaler
+
+import (
+	"sort"
+	"time"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// CordonPolicy decides which nodes planScale prefers to uncordon and
+// cordon, so operators can plug in a packing strategy suited to their
+// workload instead of being stuck with one hard-coded heuristic.
+type CordonPolicy interface {
+	// ScoreForUncordon scores a cordoned node as a candidate to bring
+	// back into service; the highest scoring node is uncordoned first.
+	ScoreForUncordon(node v1.Node, nl *nodeList) float64
+
+	// ScoreForCordon scores an available node as a candidate to take out
+	// of service; the highest scoring node is cordoned first.
+	ScoreForCordon(node v1.Node, nl *nodeList) float64
+}
+
+// sortByScore orders nodes so that the highest scoring come first.
+func sortByScore(nodes []v1.Node, score func(v1.Node) float64) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return score(nodes[j]) < score(nodes[i])
+	})
+}
+
+// CPUPackingPolicy is the original, CPU-only policy: uncordon whichever
+// cordoned node already has the most CPU requested against it first (to
+// keep bin-packing tight), and cordon whichever available node has the
+// fewest pods scheduled on it first (to disrupt the fewest games). The
+// cordon score counts every pod on the node, not only game session pods.
+type CPUPackingPolicy struct{}
+
+// ScoreForUncordon implements CordonPolicy.
+func (CPUPackingPolicy) ScoreForUncordon(node v1.Node, nl *nodeList) float64 {
+	return float64(nl.sumResourceLimit(node, v1.ResourceCPU))
+}
+
+// ScoreForCordon implements CordonPolicy.
+func (CPUPackingPolicy) ScoreForCordon(node v1.Node, nl *nodeList) float64 {
+	return -float64(len(nl.nodePods(node).Items))
+}
+
+// WeightedPolicy scores nodes as a weighted combination of CPU request
+// sum, memory request sum, game session pod count and node age, modelled
+// on MongoDB's balancer style multi-dimensional scoring. It lets
+// deployments with memory bound game servers (simulation games, for
+// example) make sensible packing decisions instead of only looking at
+// CPU, and gives a single extension point for further custom strategies.
+type WeightedPolicy struct {
+	CPUWeight     float64
+	MemWeight     float64
+	SessionWeight float64
+	AgeWeight     float64
+
+	// Now is the time source used to score node age. Defaults to
+	// time.Now if unset.
+	Now func() time.Time
+}
+
+func (p WeightedPolicy) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
+
+// score combines the four weighted dimensions for node. ScoreForUncordon
+// and ScoreForCordon both build on it - they only differ in which
+// direction the ranking should run.
+func (p WeightedPolicy) score(node v1.Node, nl *nodeList) float64 {
+	cpu := float64(nl.sumResourceLimit(node, v1.ResourceCPU))
+	mem := float64(nl.sumResourceLimit(node, v1.ResourceMemory))
+	sessions := float64(len(filterGameSessionPods(nl.nodePods(node).Items)))
+	age := p.now().Sub(node.ObjectMeta.CreationTimestamp.Time).Seconds()
+
+	return p.CPUWeight*cpu + p.MemWeight*mem + p.SessionWeight*sessions + p.AgeWeight*age
+}
+
+// ScoreForUncordon implements CordonPolicy: the highest scoring (most
+// loaded, oldest) cordoned nodes are uncordoned first.
+func (p WeightedPolicy) ScoreForUncordon(node v1.Node, nl *nodeList) float64 {
+	return p.score(node, nl)
+}
+
+// ScoreForCordon implements CordonPolicy: the lowest scoring (least
+// loaded, youngest) available nodes are cordoned first.
+func (p WeightedPolicy) ScoreForCordon(node v1.Node, nl *nodeList) float64 {
+	return -p.score(node, nl)
+}
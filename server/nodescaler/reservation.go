@@ -0,0 +1,90 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodescaler
+
+import (
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// reservation is a speculative, in-memory overlay on top of a nodeList
+// snapshot. planScale uses it to try out a sequence of cordon/uncordon
+// decisions against the snapshot and see whether they add up to enough
+// buffer, without ever re-reading cluster state - so the node list can't
+// change out from under the plan midway through building it.
+type reservation struct {
+	// remainingReservableCPU tracks, per node name, how much CPU (in
+	// milli-units) is still available to reserve against that node.
+	remainingReservableCPU map[string]int64
+	// cordoned tracks, per node name, the speculative cordon state of
+	// the node, overlaid on top of its real Spec.Unschedulable value.
+	cordoned map[string]bool
+}
+
+// newReservation takes a snapshot of nl's current unrequested CPU
+// capacity and cordon state, to be spoken for by subsequent calls to reserve/release.
+func newReservation(nl *nodeList) *reservation {
+	r := &reservation{
+		remainingReservableCPU: map[string]int64{},
+		cordoned:               map[string]bool{},
+	}
+
+	for _, n := range nl.nodes.Items {
+		capacity := n.Status.Capacity[v1.ResourceCPU]
+		r.remainingReservableCPU[n.Name] = capacity.MilliValue() - nl.sumResourceLimit(n, v1.ResourceCPU)
+		r.cordoned[n.Name] = n.Spec.Unschedulable
+	}
+
+	return r
+}
+
+// reserve speculatively uncordons n, and reserves up to cpuRequest
+// milli-cores of its remaining capacity. It returns how much of
+// cpuRequest could actually be satisfied by n. If n has no reservable
+// capacity left, it is left cordoned in the overlay - there's no point
+// uncordoning a node that can't take any of the request.
+func (r *reservation) reserve(n v1.Node, cpuRequest int64) int64 {
+	available := r.remainingReservableCPU[n.Name]
+	if available <= 0 || cpuRequest <= 0 {
+		return 0
+	}
+
+	taken := available
+	if taken > cpuRequest {
+		taken = cpuRequest
+	}
+	r.remainingReservableCPU[n.Name] -= taken
+	r.cordoned[n.Name] = false
+
+	return taken
+}
+
+// release speculatively cordons n, removing it from consideration for
+// any further reservations in this plan.
+func (r *reservation) release(n v1.Node) {
+	r.cordoned[n.Name] = true
+	delete(r.remainingReservableCPU, n.Name)
+}
+
+// availableCount returns how many of nodes are not currently cordoned,
+// according to this reservation's overlay.
+func (r *reservation) availableCount(nodes []v1.Node) int64 {
+	var count int64
+	for _, n := range nodes {
+		if !r.cordoned[n.Name] {
+			count++
+		}
+	}
+	return count
+}
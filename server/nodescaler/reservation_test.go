@@ -0,0 +1,82 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodescaler
+
+import (
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestReservationReserveDoesNotUncordonWhenNoCapacity(t *testing.T) {
+	n := v1.Node{ObjectMeta: v1.ObjectMeta{Name: "full"}}
+	r := &reservation{
+		remainingReservableCPU: map[string]int64{"full": 0},
+		cordoned:               map[string]bool{"full": true},
+	}
+
+	taken := r.reserve(n, 100)
+
+	if taken != 0 {
+		t.Fatalf("expected 0 taken from a node with no reservable capacity, got %v", taken)
+	}
+	if !r.cordoned["full"] {
+		t.Error("expected a fully-packed node to remain cordoned in the overlay")
+	}
+}
+
+func TestReservationReserveUncordonsOnPartialTake(t *testing.T) {
+	n := v1.Node{ObjectMeta: v1.ObjectMeta{Name: "roomy"}}
+	r := &reservation{
+		remainingReservableCPU: map[string]int64{"roomy": 50},
+		cordoned:               map[string]bool{"roomy": true},
+	}
+
+	taken := r.reserve(n, 100)
+
+	if taken != 50 {
+		t.Fatalf("expected to take all 50 remaining milli-cores, got %v", taken)
+	}
+	if r.cordoned["roomy"] {
+		t.Error("expected a node that took reservation to be uncordoned in the overlay")
+	}
+	if r.remainingReservableCPU["roomy"] != 0 {
+		t.Errorf("expected remaining capacity to be drained to 0, got %v", r.remainingReservableCPU["roomy"])
+	}
+}
+
+func TestReservationReleaseCordonsNode(t *testing.T) {
+	n := v1.Node{ObjectMeta: v1.ObjectMeta{Name: "n"}}
+	r := &reservation{cordoned: map[string]bool{"n": false}}
+
+	r.release(n)
+
+	if !r.cordoned["n"] {
+		t.Error("expected release to cordon the node in the overlay")
+	}
+}
+
+func TestReservationAvailableCount(t *testing.T) {
+	nodes := []v1.Node{
+		{ObjectMeta: v1.ObjectMeta{Name: "a"}},
+		{ObjectMeta: v1.ObjectMeta{Name: "b"}},
+		{ObjectMeta: v1.ObjectMeta{Name: "c"}},
+	}
+	r := &reservation{cordoned: map[string]bool{"a": false, "b": true, "c": false}}
+
+	if got := r.availableCount(nodes); got != 2 {
+		t.Errorf("expected 2 available nodes, got %v", got)
+	}
+}
@@ -16,8 +16,6 @@ package nodescaler
 
 import (
 	"log"
-	"math"
-	"sort"
 
 	"github.com/pkg/errors"
 	"k8s.io/client-go/pkg/api/v1"
@@ -31,173 +29,156 @@ type NodePool interface {
 	// Should ignore requests to make the nodepool smaller
 	IncreaseToSize(int64) error
 
-	// Delete specific nodes in the cluster
+	// Increase the pool of nodes within a single zone to a given size.
+	// Should ignore requests to make the zone's share of the nodepool smaller
+	IncreaseZoneToSize(zone string, size int64) error
+
+	// Bounds returns the min and max number of nodes the pool is allowed
+	// to hold, as read from the backing infrastructure (e.g. a GCE
+	// Managed Instance Group's autoscaling config).
+	Bounds() (min, max int64, err error)
+
+	// Delete specific nodes in the cluster. Implementations should attempt
+	// every node independently, so that a failure deleting a node in one
+	// zone doesn't prevent deletion of nodes in other zones.
 	DeleteNodes(nodes []v1.Node) error
 }
 
 // scale scales nodes up and down, depending on CPU constraints
-// this includes adding nodes, cordoning them as well as deleting them
+// this includes adding nodes, cordoning them as well as deleting them.
+//
+// scaleNodes takes a single snapshot of cluster state, builds a scalePlan
+// against it, and executes that plan. Previously this took a node list,
+// made a decision, and then re-fetched the node list to recalculate
+// before acting again - leaving a window where pods scheduled in between
+// the two fetches could cause the second decision to over- or
+// under-provision. planScale now reasons about the whole round against
+// one snapshot, via speculative reservations, so there is no re-fetch
+// between decisions. Only deleteCordonedNodes reads the node list again,
+// after the plan has been executed.
 func (s Server) scaleNodes() error {
 	nl, err := s.newNodeList()
 	if err != nil {
 		return err
 	}
 
-	available := nl.cpuRequestsAvailable()
-	log.Printf("[Info][scaleNodes] CPU Requests blocks of %vm. Available: %v. Requires a buffer of %v", s.cpuRequest, available, s.bufferCount)
-	// TODO: make sure there is always a min number of nodes
-	if available < s.bufferCount {
-		finished, err := s.uncordonNodes(nl, s.bufferCount-available)
-		// short circuit if uncordoning means we have enough buffer now
-		if err != nil || finished {
-			return err
-		}
-
-		nl, err := s.newNodeList()
-		if err != nil {
-			return err
-		}
-		// recalculate
-		available = nl.cpuRequestsAvailable()
-		err = s.increaseNodes(nl, s.bufferCount-available)
-		if err != nil {
-			return err
-		}
-
-	} else if s.bufferCount < available {
-		err := s.cordonNodes(nl, available-s.bufferCount)
-		if err != nil {
-			return err
-		}
+	min, max, err := s.nodeBounds()
+	if err != nil {
+		return err
 	}
 
-	return s.deleteCordonedNodes()
-}
-
-// increaseNodes increases the size of the managed nodepool
-// by a given number of cpu blocks
-func (s Server) increaseNodes(nl *nodeList, gameNumber int64) error {
-	if gameNumber <= 0 {
-		log.Printf("[Warn][IncreaseNodes] Cannot increase nodes by a 0 or negative number. %v", gameNumber)
-		return nil
+	if s.metrics != nil {
+		s.metrics.ReportNodeCounts(int64(len(nl.availableNodes())), min, max)
 	}
 
-	// TODO: make sure increase nodes doesn't go over max
-
-	log.Printf("[Info][increaseNodes] Attempting to increase nodelist of %v, by %v cpu blocks", len(nl.nodes.Items), gameNumber)
-
-	// determine how many nodes we need to be equal to or greater
-	// than the bufferCount requirements.
-
-	// this won't be totally accurate, since there are a few more containers on there
-	// but it will do for now, and if not, the next round will pull it up
-	capacity := nl.nodes.Items[0].Status.Capacity[v1.ResourceCPU]
-	cpuRequest := gameNumber * s.cpuRequest
-	diff := int64(math.Ceil(float64(cpuRequest) / float64(capacity.MilliValue())))
-	log.Printf("[Info][increaseNodes] Adding %v nodes to the node pool", diff)
-
-	return s.nodePool.IncreaseToSize(int64(len(nl.availableNodes())) + diff)
-}
-
-// uncordonNodes searches through all the available nodes and uncordons
-// those that are most at capacity up to the gameNumber limit
-// returns true if it successfully fullfilled the gameNumber request that was passed in
-func (s Server) uncordonNodes(nl *nodeList, gameNumber int64) (bool, error) {
-	if gameNumber <= 0 {
-		log.Printf("[Warn][uncordonNodes] Cannot uncordon nodes by a 0 or negative number. %v", gameNumber)
-		return true, nil
+	plan, planErr := s.planScale(nl, min, max)
+	if plan == nil {
+		return planErr
 	}
 
-	// first we need to take all nodes, and sort by decreasing allocatable value
-	nodes := nl.cordonedNodes()
-	if len(nodes) == 0 {
-		log.Print("[Info][uncordonNodes] No nodes that are unscheduled, exiting...")
-		return false, nil
+	for _, v := range plan.VerdictSet {
+		log.Printf("[Info][scaleNodes] Zone %v: %v", zoneName(v.zone), v.reason)
 	}
 
-	// sort by the node that has the most pods on it currently (least availability)
-	sort.Slice(nodes, func(i, j int) bool {
-		iSum := nl.sumResourceLimit(nodes[i], v1.ResourceCPU)
-		jSum := nl.sumResourceLimit(nodes[j], v1.ResourceCPU)
-
-		return jSum < iSum
-	})
-
-	cpuRequest := s.cpuRequest * gameNumber
-
-	log.Printf("[Info][uncordonNodes] Uncordoning nodes. Requesting %v game servers for a cpuRequest of %v", gameNumber, cpuRequest)
-
-	for _, n := range nodes {
-		capacity := n.Status.Capacity[v1.ResourceCPU]
-		err := s.cordon(&n, false)
-		if err != nil {
-			return false, err
-		}
-
-		available := capacity.MilliValue() - nl.sumResourceLimit(n, v1.ResourceCPU)
-		cpuRequest = cpuRequest - available
-		log.Printf("[Info][uncordonNodes] %v cpuRequest remaining after uncordoning node, and adding %v cpu", cpuRequest, available)
-
-		if cpuRequest <= 0 {
-			return true, nil
-		}
+	if err := plan.Execute(); err != nil {
+		return err
 	}
-	return false, nil
-}
 
-// cordonNodes decrease the number of available nodes by the given number of cpu blocks (but not over),
-// but cordoning those nodes that have the least number of games currently on them
-func (s Server) cordonNodes(nl *nodeList, gameNumber int64) error {
-	if gameNumber <= 0 {
-		log.Printf("[Warn][CordonNodes] Cannot cordon nodes by a 0 or negative number. %v", gameNumber)
-		return nil
+	if err := s.deleteCordonedNodes(min); err != nil {
+		return err
 	}
 
-	// how many nodes (n) do we have to delete such that we are cordoning no more
-	// than the gameNumber
-	capacity := nl.nodes.Items[0].Status.Capacity[v1.ResourceCPU] //assuming all nodes are the same
-	cpuRequest := gameNumber * s.cpuRequest
-	diff := int64(math.Floor(float64(cpuRequest) / float64(capacity.MilliValue())))
+	// surface a capped increase after acting on the rest of the plan, so
+	// operators can alert on it without the round otherwise stalling
+	return planErr
+}
 
-	if diff <= 0 {
-		log.Print("[Info][CordonNodes] No nodes to be cordoned.")
-		return nil
+// nodeBounds combines the operator supplied MinNodes/MaxNodes with the
+// bounds reported by the backing node pool, so that either can further
+// restrict the other. A zero MinNodes/MaxNodes means "no extra bound
+// beyond what the node pool itself reports".
+func (s Server) nodeBounds() (min, max int64, err error) {
+	pmin, pmax, err := s.nodePool.Bounds()
+	if err != nil {
+		return 0, 0, err
 	}
 
-	log.Printf("[Info][CordonNodes] Cordoning %v nodes", diff)
+	min = pmin
+	if s.MinNodes > min {
+		min = s.MinNodes
+	}
 
-	// sort the nodes, such that the one with the least number of games are first
-	nodes := nl.nodes.Items
-	sort.Slice(nodes, func(i, j int) bool {
-		return len(nl.nodePods(nodes[i]).Items) < len(nl.nodePods(nodes[j]).Items)
-	})
+	max = pmax
+	if s.MaxNodes > 0 && (max <= 0 || s.MaxNodes < max) {
+		max = s.MaxNodes
+	}
 
-	// grab the first n number of them
-	cNodes := nodes[0:diff]
+	return min, max, nil
+}
 
-	// cordon them all
-	for _, n := range cNodes {
-		log.Printf("[Info][CordonNodes] Cordoning node: %v", n.Name)
-		err := s.cordon(&n, true)
-		if err != nil {
-			return err
-		}
+// effectiveBufferCount returns the buffer targets the increase and cordon
+// decisions should each use, derived from the configured bufferCount and
+// the demand predicted by s.gameServerCounter over the time it takes a
+// freshly increased node to become useful (shutdown grace period plus
+// however long the increase itself takes to land). A rising allocation
+// trend raises increase, so nodes are pre-warmed before the static buffer
+// is exhausted; a falling trend lowers cordon, so cordoning can act
+// sooner, ahead of the static buffer alone.
+func (s Server) effectiveBufferCount() (increase, cordon int64) {
+	if s.gameServerCounter == nil {
+		return s.bufferCount, s.bufferCount
 	}
 
-	return nil
+	predicted := s.gameServerCounter.PredictedDemand(s.shutdown + s.scaleLatency)
+	switch {
+	case predicted > s.bufferCount:
+		log.Printf("[Info][effectiveBufferCount] Allocation trend predicts demand of %v over the next %v, raising increase buffer from %v", predicted, s.shutdown+s.scaleLatency, s.bufferCount)
+		return predicted, s.bufferCount
+	case predicted < s.bufferCount && s.gameServerCounter.AllocationRate() < 0:
+		log.Printf("[Info][effectiveBufferCount] Allocation trend predicts demand of %v over the next %v, lowering cordon buffer from %v", predicted, s.shutdown+s.scaleLatency, s.bufferCount)
+		return s.bufferCount, predicted
+	default:
+		return s.bufferCount, s.bufferCount
+	}
 }
 
 // deleteCordonedNodes will delete a cordoned node if it
-// the time since it was cordoned has expired
-func (s Server) deleteCordonedNodes() error {
-	// TODO: make sure delete doesn't got past the min number
+// the time since it was cordoned has expired. Nodes in a disrupted zone
+// are left alone, since deleting them could make a zone outage worse.
+// It will not delete nodes once the total node count has reached min.
+func (s Server) deleteCordonedNodes(min int64) error {
 	nl, err := s.newNodeList()
 	if err != nil {
 		return err
 	}
 
+	disrupted := map[string]bool{}
+	for _, z := range summarizeZones(nl, s.bufferCount, s.bufferCount, defaultZoneDisruptionThreshold) {
+		if z.state != zoneNormal {
+			disrupted[z.name] = true
+		}
+	}
+
+	// min bounds the total node count here, not the available count:
+	// planCordon already refuses to cordon the available count below
+	// min, so by the time a node is sitting here drained and past
+	// its shutdown grace period, deleting it can't take available below
+	// min - it was never counted as available in the first place. What
+	// min does need to protect is the floor on total nodes in the pool,
+	// so reaping doesn't run unbounded.
+	total := int64(len(nl.nodes.Items))
+
 	var dn []v1.Node
 	for _, n := range nl.cordonedNodes() {
+		if total <= min {
+			log.Printf("[Info][deleteCordonedNodes] At min node count of %v, leaving remaining cordoned nodes in place", min)
+			break
+		}
+
+		if disrupted[n.ObjectMeta.Labels[zoneLabel]] {
+			continue
+		}
+
 		ct, err := cordonTimestamp(n)
 		if err != nil {
 			return err
@@ -211,6 +192,7 @@ func (s Server) deleteCordonedNodes() error {
 				return errors.Wrapf(err, "Error deleting cordoned node: %v", n.Name)
 			}
 			dn = append(dn, n)
+			total--
 		}
 	}
 
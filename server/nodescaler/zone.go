@@ -0,0 +1,238 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodescaler
+
+import (
+	"sort"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// zoneLabel is the well known Kubernetes label that GCE (and other cloud
+// providers) set on a Node to indicate which failure domain it lives in.
+const zoneLabel = "failure-domain.beta.kubernetes.io/zone"
+
+// defaultZoneDisruptionThreshold is the fraction of NotReady/unreachable
+// nodes in a zone, above which the zone is considered partiallyDisrupted.
+const defaultZoneDisruptionThreshold = 0.55
+
+// zoneState describes the health of a zone, modelled on the states used
+// by the Kubernetes node lifecycle controller.
+type zoneState int
+
+const (
+	// zoneNormal means the zone is healthy, and can be cordoned, uncordoned
+	// and deleted from as normal.
+	zoneNormal zoneState = iota
+	// zonePartiallyDisrupted means more than the configured threshold of
+	// nodes in the zone are NotReady or unreachable.
+	zonePartiallyDisrupted
+	// zoneFullyDisrupted means no nodes in the zone are Ready.
+	zoneFullyDisrupted
+)
+
+// String implements fmt.Stringer, for logging.
+func (z zoneState) String() string {
+	switch z {
+	case zoneNormal:
+		return "normal"
+	case zonePartiallyDisrupted:
+		return "partiallyDisrupted"
+	case zoneFullyDisrupted:
+		return "fullyDisrupted"
+	default:
+		return "unknown"
+	}
+}
+
+// zoneSummary is the set of nodes that belong to a single zone, along
+// with its computed state and its share of the overall buffer target.
+// buffer and cordonBuffer can differ: a rising allocation trend raises
+// buffer (the increase decision's target) without affecting cordonBuffer
+// (the cordon decision's target), and a falling trend lowers cordonBuffer
+// without affecting buffer, so each decision reacts to the trend in the
+// direction that helps.
+type zoneSummary struct {
+	name         string
+	nodes        []v1.Node
+	state        zoneState
+	buffer       int64
+	cordonBuffer int64
+}
+
+// zoneName returns a display friendly name for a zone, since nodes
+// without the zoneLabel are grouped under the empty string.
+func zoneName(zone string) string {
+	if zone == "" {
+		return "<unzoned>"
+	}
+	return zone
+}
+
+// filterZone returns the subset of nodes that belong to zone. If zone is
+// the empty string, nodes is returned unchanged, since that's the
+// behaviour a single zone (or unzoned) cluster should see.
+func filterZone(nodes []v1.Node, zone string) []v1.Node {
+	if zone == "" {
+		return nodes
+	}
+
+	var result []v1.Node
+	for _, n := range nodes {
+		if n.ObjectMeta.Labels[zoneLabel] == zone {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// nodeReady returns true if a Node's Ready condition is true.
+func nodeReady(n v1.Node) bool {
+	for _, c := range n.Status.Conditions {
+		if c.Type == v1.NodeReady {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// stateForZone computes the zoneState for a set of nodes that all belong
+// to the same zone, given the disruption threshold to apply.
+func stateForZone(nodes []v1.Node, threshold float64) zoneState {
+	if len(nodes) == 0 {
+		return zoneNormal
+	}
+
+	var ready int
+	for _, n := range nodes {
+		if nodeReady(n) {
+			ready++
+		}
+	}
+
+	if ready == 0 {
+		return zoneFullyDisrupted
+	}
+	if float64(len(nodes)-ready)/float64(len(nodes)) > threshold {
+		return zonePartiallyDisrupted
+	}
+
+	return zoneNormal
+}
+
+// summarizeZones partitions nl by the zoneLabel, computes the zoneState of
+// each zone, and splits increaseBufferCount and cordonBufferCount across
+// the zones that are zoneNormal, each weighted by the zone's share of the
+// node count across all healthy zones. Disrupted zones are returned with
+// both buffers at 0, so that their share of the demand is redistributed
+// to the healthy zones. The two buffer counts are usually equal, but
+// Server.effectiveBufferCount lets them diverge so a rising allocation
+// trend can raise the increase target without also raising the cordon
+// target, and a falling trend can lower the cordon target without also
+// lowering the increase target.
+func summarizeZones(nl *nodeList, increaseBufferCount, cordonBufferCount int64, threshold float64) []zoneSummary {
+	byZone := map[string][]v1.Node{}
+	for _, n := range nl.nodes.Items {
+		z := n.ObjectMeta.Labels[zoneLabel]
+		byZone[z] = append(byZone[z], n)
+	}
+
+	summaries := make([]zoneSummary, 0, len(byZone))
+	var healthyNodes int64
+	for zone, nodes := range byZone {
+		state := stateForZone(nodes, threshold)
+		if state == zoneNormal {
+			healthyNodes += int64(len(nodes))
+		}
+		summaries = append(summaries, zoneSummary{name: zone, nodes: nodes, state: state})
+	}
+
+	if healthyNodes > 0 {
+		increase := distributeBuffer(summaries, increaseBufferCount, healthyNodes)
+		cordon := distributeBuffer(summaries, cordonBufferCount, healthyNodes)
+		for i := range summaries {
+			summaries[i].buffer = increase[i]
+			summaries[i].cordonBuffer = cordon[i]
+		}
+	}
+
+	sortZoneSummaries(summaries)
+	return summaries
+}
+
+// distributeBuffer splits bufferCount proportionally across the healthy
+// (zoneNormal) zones in summaries, weighted by each zone's share of
+// healthyNodes, and returns the result indexed the same way as summaries
+// (0 for zones that aren't zoneNormal). Integer division leaves a
+// remainder; it is handed out one unit at a time to the zones with the
+// largest fractional remainder, ties broken by zone name, so the result
+// is deterministic regardless of map iteration order.
+func distributeBuffer(summaries []zoneSummary, bufferCount, healthyNodes int64) []int64 {
+	result := make([]int64, len(summaries))
+
+	type remainder struct {
+		idx   int
+		value int64
+	}
+	var remainders []remainder
+	var assigned int64
+	for i := range summaries {
+		if summaries[i].state != zoneNormal {
+			continue
+		}
+		nodes := int64(len(summaries[i].nodes))
+		base := bufferCount * nodes / healthyNodes
+		result[i] = base
+		assigned += base
+		remainders = append(remainders, remainder{idx: i, value: bufferCount*nodes - base*healthyNodes})
+	}
+
+	sort.Slice(remainders, func(i, j int) bool {
+		if remainders[i].value != remainders[j].value {
+			return remainders[i].value > remainders[j].value
+		}
+		return summaries[remainders[i].idx].name < summaries[remainders[j].idx].name
+	})
+	for i := int64(0); i < bufferCount-assigned && i < int64(len(remainders)); i++ {
+		result[remainders[i].idx]++
+	}
+
+	return result
+}
+
+// sortZoneSummaries orders summaries by zone name, so that callers see a
+// deterministic zone order regardless of the Go map iteration order
+// byZone was built from. This keeps planScale's step order, VerdictSet
+// order, and which zone absorbs a max cap stable across runs, and makes
+// multi-zone inputs testable.
+func sortZoneSummaries(summaries []zoneSummary) {
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].name < summaries[j].name })
+}
+
+// zoneCPURequestsAvailable sums the available (capacity minus
+// requested) CPU across the given nodes, ignoring cordoned nodes, and
+// expresses it in blocks of cpuRequest millicores - the same units as
+// nodeList.cpuRequestsAvailable and Server.bufferCount.
+func zoneCPURequestsAvailable(nl *nodeList, nodes []v1.Node, cpuRequest int64) int64 {
+	var available int64
+	for _, n := range nodes {
+		if n.Spec.Unschedulable {
+			continue
+		}
+		capacity := n.Status.Capacity[v1.ResourceCPU]
+		available += capacity.MilliValue() - nl.sumResourceLimit(n, v1.ResourceCPU)
+	}
+	return available / cpuRequest
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodescaler
+
+import (
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// readyNode returns a v1.Node in zone with the given Ready condition.
+func readyNode(name, zone string, ready bool) v1.Node {
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	return v1.Node{
+		ObjectMeta: v1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{zoneLabel: zone},
+		},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: status}},
+		},
+	}
+}
+
+func TestSummarizeZonesSortsByName(t *testing.T) {
+	nl := &nodeList{nodes: v1.NodeList{Items: []v1.Node{
+		readyNode("z-c-0", "c", true),
+		readyNode("z-a-0", "a", true),
+		readyNode("z-b-0", "b", true),
+	}}}
+
+	// run a few times - map iteration order is randomized per run, so a
+	// single pass isn't enough to catch a regression back to unsorted.
+	for i := 0; i < 10; i++ {
+		summaries := summarizeZones(nl, 3, 3, defaultZoneDisruptionThreshold)
+		if len(summaries) != 3 {
+			t.Fatalf("expected 3 zones, got %v", len(summaries))
+		}
+		if summaries[0].name != "a" || summaries[1].name != "b" || summaries[2].name != "c" {
+			t.Fatalf("expected zones sorted a, b, c; got %v, %v, %v", summaries[0].name, summaries[1].name, summaries[2].name)
+		}
+	}
+}
+
+func TestSummarizeZonesProportionalSplit(t *testing.T) {
+	nl := &nodeList{nodes: v1.NodeList{Items: []v1.Node{
+		readyNode("a-0", "a", true),
+		readyNode("b-0", "b", true),
+		readyNode("b-1", "b", true),
+		readyNode("b-2", "b", true),
+	}}}
+
+	summaries := summarizeZones(nl, 8, 8, defaultZoneDisruptionThreshold)
+
+	var byName = map[string]zoneSummary{}
+	for _, z := range summaries {
+		byName[z.name] = z
+	}
+
+	// zone a has 1 of 4 healthy nodes (8*1/4=2), zone b has 3 of 4 (8*3/4=6)
+	if got := byName["a"].buffer; got != 2 {
+		t.Errorf("expected zone a buffer 2, got %v", got)
+	}
+	if got := byName["b"].buffer; got != 6 {
+		t.Errorf("expected zone b buffer 6, got %v", got)
+	}
+}
+
+func TestSummarizeZonesDisruptedZoneRedistribution(t *testing.T) {
+	nl := &nodeList{nodes: v1.NodeList{Items: []v1.Node{
+		readyNode("a-0", "a", true),
+		readyNode("b-0", "b", false),
+		readyNode("b-1", "b", false),
+	}}}
+
+	summaries := summarizeZones(nl, 4, 4, defaultZoneDisruptionThreshold)
+
+	var byName = map[string]zoneSummary{}
+	for _, z := range summaries {
+		byName[z.name] = z
+	}
+
+	if state := byName["b"].state; state != zoneFullyDisrupted {
+		t.Fatalf("expected zone b to be fullyDisrupted, got %v", state)
+	}
+	if got := byName["b"].buffer; got != 0 {
+		t.Errorf("expected disrupted zone b to get no buffer share, got %v", got)
+	}
+	// zone b's share of the demand should be redistributed to zone a,
+	// the only remaining healthy zone.
+	if got := byName["a"].buffer; got != 4 {
+		t.Errorf("expected zone a to absorb zone b's share, got %v", got)
+	}
+}
+
+func TestSummarizeZonesIndependentIncreaseAndCordonBuffers(t *testing.T) {
+	nl := &nodeList{nodes: v1.NodeList{Items: []v1.Node{
+		readyNode("a-0", "a", true),
+	}}}
+
+	summaries := summarizeZones(nl, 5, 2, defaultZoneDisruptionThreshold)
+	if len(summaries) != 1 {
+		t.Fatalf("expected 1 zone, got %v", len(summaries))
+	}
+	if got := summaries[0].buffer; got != 5 {
+		t.Errorf("expected increase buffer 5, got %v", got)
+	}
+	if got := summaries[0].cordonBuffer; got != 2 {
+		t.Errorf("expected cordon buffer 2, got %v", got)
+	}
+}